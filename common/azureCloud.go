@@ -0,0 +1,99 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import "os"
+
+// AzureCloud identifies a sovereign Azure cloud instance. azcopy needs to
+// know which one it's talking to because the AAD login endpoint, the storage
+// resource ID and the registered application ID all differ per cloud.
+type AzureCloud string
+
+const (
+	AzurePublic       AzureCloud = "AzurePublic"
+	AzureUSGovernment AzureCloud = "AzureUSGovernment"
+	AzureChina        AzureCloud = "AzureChina"
+	AzureGermany      AzureCloud = "AzureGermany"
+)
+
+// azureCloudInfo bundles the per-cloud values LoginWithADEndpoint and the
+// token manager need to authenticate a user against a particular cloud.
+type azureCloudInfo struct {
+	ActiveDirectoryEndpoint string
+	StorageResource         string
+	ApplicationID           string
+	ManagementEndpoint      string
+}
+
+// azCopyApplicationIDEnvVar lets an install register its own application ID
+// for a sovereign cloud azcopy doesn't ship one for (see the clouds table
+// below). Azure doesn't let an application created in AzurePublic sign in
+// against Gov/China/Germany tenants, so reusing ApplicationID there would
+// fail outright rather than just being untested.
+const azCopyApplicationIDEnvVar = "AZCOPY_APPLICATION_ID"
+
+// clouds maps each supported AzureCloud to its endpoints, following the
+// packer-azure pattern of a clientIDs-style table keyed by cloud name.
+// ApplicationID is only populated for AzurePublic, where azcopy-v2's test
+// app registration (see its doc comment) actually lives; the sovereign
+// clouds are left blank on purpose rather than shipping an app ID that's
+// registered in the wrong cloud and would just fail sign-in; see
+// AZCOPY_APPLICATION_ID and cloudInfo below.
+var clouds = map[AzureCloud]azureCloudInfo{
+	AzurePublic: {
+		ActiveDirectoryEndpoint: DefaultActiveDirectoryEndpoint,
+		StorageResource:         Resource,
+		ApplicationID:           ApplicationID,
+		ManagementEndpoint:      "https://management.azure.com",
+	},
+	AzureUSGovernment: {
+		ActiveDirectoryEndpoint: "https://login.microsoftonline.us",
+		StorageResource:         "https://storage.azure.us",
+		ManagementEndpoint:      "https://management.usgovcloudapi.net",
+	},
+	AzureChina: {
+		ActiveDirectoryEndpoint: "https://login.chinacloudapi.cn",
+		StorageResource:         "https://storage.azure.cn",
+		ManagementEndpoint:      "https://management.chinacloudapi.cn",
+	},
+	AzureGermany: {
+		ActiveDirectoryEndpoint: "https://login.microsoftonline.de",
+		StorageResource:         "https://storage.azure.de",
+		ManagementEndpoint:      "https://management.microsoftazure.de",
+	},
+}
+
+// cloudInfo returns the endpoints registered for cloud, falling back to
+// AzurePublic when cloud is empty or unrecognized. If the table has no
+// ApplicationID for cloud, AZCOPY_APPLICATION_ID is used instead; callers
+// that need one (LoginWithADEndpoint) must still check for "" themselves
+// and fail with an actionable error rather than attempting sign-in with an
+// application ID that isn't registered in that cloud.
+func cloudInfo(cloud AzureCloud) azureCloudInfo {
+	info, ok := clouds[cloud]
+	if !ok {
+		info = clouds[AzurePublic]
+	}
+	if info.ApplicationID == "" {
+		info.ApplicationID = os.Getenv(azCopyApplicationIDEnvVar)
+	}
+	return info
+}
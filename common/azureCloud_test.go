@@ -0,0 +1,50 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import "testing"
+
+func TestCloudInfoApplicationID(t *testing.T) {
+	if got := cloudInfo(AzurePublic).ApplicationID; got != ApplicationID {
+		t.Fatalf("AzurePublic should use the baked-in ApplicationID, got %q", got)
+	}
+
+	t.Run("sovereign cloud without override has no application ID", func(t *testing.T) {
+		t.Setenv(azCopyApplicationIDEnvVar, "")
+		if got := cloudInfo(AzureUSGovernment).ApplicationID; got != "" {
+			t.Fatalf("expected no ApplicationID for AzureUSGovernment without an override, got %q", got)
+		}
+	})
+
+	t.Run("sovereign cloud honors AZCOPY_APPLICATION_ID override", func(t *testing.T) {
+		t.Setenv(azCopyApplicationIDEnvVar, "11111111-1111-1111-1111-111111111111")
+		got := cloudInfo(AzureUSGovernment).ApplicationID
+		if got != "11111111-1111-1111-1111-111111111111" {
+			t.Fatalf("expected override to be used, got %q", got)
+		}
+	})
+
+	t.Run("unrecognized cloud falls back to AzurePublic", func(t *testing.T) {
+		if got := cloudInfo(AzureCloud("not-a-real-cloud")).ApplicationID; got != ApplicationID {
+			t.Fatalf("expected fallback to AzurePublic's ApplicationID, got %q", got)
+		}
+	})
+}
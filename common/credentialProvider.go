@@ -0,0 +1,501 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"golang.org/x/crypto/pkcs12"
+)
+
+// AuthMode identifies the credential provider (or chain of providers) that
+// azcopy's --auth-mode flag selects.
+type AuthMode string
+
+// Supported values for --auth-mode. AuthModeAuto walks the default chain
+// (environment, managed identity, Azure CLI) and is what azcopy uses when
+// the flag isn't set and no cached device-code token exists.
+const (
+	AuthModeAuto              AuthMode = "auto"
+	AuthModeDevice            AuthMode = "device"
+	AuthModeClientSecret      AuthMode = "clientsecret"
+	AuthModeClientCertificate AuthMode = "clientcertificate"
+	AuthModeManagedIdentity   AuthMode = "managedidentity"
+	AuthModeAzureCLI          AuthMode = "azurecli"
+	AuthModeEnvironment       AuthMode = "environment"
+)
+
+// CredentialProvider abstracts over the different ways azcopy can obtain an
+// OAuth token for Azure Storage, so that a chain of them can be walked in
+// order (mirroring azidentity's ChainedTokenCredential/DefaultAzureCredential)
+// without every caller needing to know which one ultimately succeeded.
+type CredentialProvider interface {
+	// Name is a short, human readable identifier used in chain error messages.
+	Name() string
+	// GetToken acquires an OAuthTokenInfo, or returns an error if this
+	// provider isn't configured/able to produce one.
+	GetToken() (*OAuthTokenInfo, error)
+}
+
+// ChainedCredentialProvider tries each of its providers in order and returns
+// the first token obtained. If every provider fails, it returns a single
+// error listing what was attempted and why each one failed.
+type ChainedCredentialProvider struct {
+	providers []CredentialProvider
+}
+
+// NewChainedCredentialProvider builds a ChainedCredentialProvider that walks
+// providers in the given order.
+func NewChainedCredentialProvider(providers ...CredentialProvider) *ChainedCredentialProvider {
+	return &ChainedCredentialProvider{providers: providers}
+}
+
+func (c *ChainedCredentialProvider) Name() string {
+	return "chained"
+}
+
+// GetToken walks the chain, returning the first successfully acquired token.
+func (c *ChainedCredentialProvider) GetToken() (*OAuthTokenInfo, error) {
+	var attempts []string
+	for _, p := range c.providers {
+		token, err := p.GetToken()
+		if err == nil {
+			return token, nil
+		}
+		attempts = append(attempts, fmt.Sprintf("  %s: %v", p.Name(), err))
+	}
+	return nil, fmt.Errorf("no credential provider in the chain succeeded:\n%s", strings.Join(attempts, "\n"))
+}
+
+// NewDefaultCredentialChain builds the provider chain used for AuthModeAuto:
+// environment-variable configured credentials, then managed identity, then
+// the Azure CLI's cached login. Device-code login is interactive and is
+// deliberately excluded from the default chain; it must be selected
+// explicitly via AuthModeDevice. resource is the cloud's storage resource ID
+// (cloudInfo(cloud).StorageResource), so tokens minted by any provider in the
+// chain are scoped to the right audience.
+func NewDefaultCredentialChain(activeDirectoryEndpoint, resource string) *ChainedCredentialProvider {
+	return NewChainedCredentialProvider(
+		NewEnvironmentCredentialProvider(activeDirectoryEndpoint, resource),
+		NewManagedIdentityCredentialProvider(resource),
+		NewAzureCLICredentialProvider(resource),
+	)
+}
+
+//====================================================================================
+// device-code credential provider
+
+// DeviceCodeCredentialProvider wraps UserOAuthTokenManager's existing
+// interactive device-code flow so it can participate in a CredentialProvider
+// chain alongside the unattended providers below.
+type DeviceCodeCredentialProvider struct {
+	uotm                    *UserOAuthTokenManager
+	tenantID                string
+	activeDirectoryEndpoint string
+}
+
+func NewDeviceCodeCredentialProvider(uotm *UserOAuthTokenManager, tenantID, activeDirectoryEndpoint string) *DeviceCodeCredentialProvider {
+	return &DeviceCodeCredentialProvider{uotm: uotm, tenantID: tenantID, activeDirectoryEndpoint: activeDirectoryEndpoint}
+}
+
+func (p *DeviceCodeCredentialProvider) Name() string {
+	return "device-code"
+}
+
+func (p *DeviceCodeCredentialProvider) GetToken() (*OAuthTokenInfo, error) {
+	return p.uotm.LoginWithADEndpoint(p.tenantID, p.activeDirectoryEndpoint, false)
+}
+
+//====================================================================================
+// client-secret credential provider
+
+// ClientSecretCredentialProvider authenticates a service principal with a
+// client secret, for unattended scenarios such as CI.
+type ClientSecretCredentialProvider struct {
+	TenantID                string
+	ClientID                string
+	ClientSecret            string
+	ActiveDirectoryEndpoint string
+	// Resource is the storage resource ID to request the token for, i.e.
+	// cloudInfo(cloud).StorageResource; it must be set to the caller's
+	// cloud, not assumed to be AzurePublic's.
+	Resource string
+}
+
+func (p *ClientSecretCredentialProvider) Name() string {
+	return "client-secret"
+}
+
+func (p *ClientSecretCredentialProvider) GetToken() (*OAuthTokenInfo, error) {
+	if p.TenantID == "" || p.ClientID == "" || p.ClientSecret == "" {
+		return nil, errors.New("tenant ID, client ID and client secret are all required")
+	}
+
+	oauthConfig, err := adal.NewOAuthConfig(p.ActiveDirectoryEndpoint, p.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	spt, err := adal.NewServicePrincipalToken(*oauthConfig, p.ClientID, p.ClientSecret, p.Resource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create service principal token: %v", err)
+	}
+	if err := spt.Refresh(); err != nil {
+		return nil, fmt.Errorf("failed to acquire token with client secret: %v", err)
+	}
+
+	return &OAuthTokenInfo{
+		Token:                   spt.Token(),
+		Tenant:                  p.TenantID,
+		ActiveDirectoryEndpoint: p.ActiveDirectoryEndpoint,
+	}, nil
+}
+
+//====================================================================================
+// client-certificate credential provider
+
+// ClientCertificateCredentialProvider authenticates a service principal with
+// a certificate, accepted either as a PKCS#12 (.pfx/.p12) file protected by
+// CertificatePassword, or as a PEM file containing both the certificate and
+// its unencrypted private key.
+type ClientCertificateCredentialProvider struct {
+	TenantID                string
+	ClientID                string
+	CertificatePath         string
+	CertificatePassword     string
+	ActiveDirectoryEndpoint string
+	// Resource is the storage resource ID to request the token for, i.e.
+	// cloudInfo(cloud).StorageResource; it must be set to the caller's
+	// cloud, not assumed to be AzurePublic's.
+	Resource string
+}
+
+func (p *ClientCertificateCredentialProvider) Name() string {
+	return "client-certificate"
+}
+
+func (p *ClientCertificateCredentialProvider) GetToken() (*OAuthTokenInfo, error) {
+	if p.TenantID == "" || p.ClientID == "" || p.CertificatePath == "" {
+		return nil, errors.New("tenant ID, client ID and certificate path are all required")
+	}
+
+	cert, key, err := loadCertificateAndKey(p.CertificatePath, p.CertificatePassword)
+	if err != nil {
+		return nil, err
+	}
+
+	oauthConfig, err := adal.NewOAuthConfig(p.ActiveDirectoryEndpoint, p.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	spt, err := adal.NewServicePrincipalTokenFromCertificate(*oauthConfig, p.ClientID, cert, key, p.Resource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create service principal token: %v", err)
+	}
+	// adal signs the RS256 client assertion (iss=sub=client ID, aud=token
+	// endpoint, x5t=SHA1 thumbprint of cert) and POSTs it as client_assertion
+	// when this is refreshed.
+	if err := spt.Refresh(); err != nil {
+		return nil, fmt.Errorf("failed to acquire token with client certificate: %v", err)
+	}
+
+	return &OAuthTokenInfo{
+		Token:                   spt.Token(),
+		Tenant:                  p.TenantID,
+		ActiveDirectoryEndpoint: p.ActiveDirectoryEndpoint,
+	}, nil
+}
+
+// loadCertificateAndKey reads certPath and returns the leaf certificate and
+// RSA private key it contains, trying PKCS#12 first and falling back to PEM.
+func loadCertificateAndKey(certPath, password string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read certificate file (%s): %v", certPath, err)
+	}
+
+	if privateKey, cert, err := pkcs12.Decode(data, password); err == nil {
+		rsaKey, ok := privateKey.(*rsa.PrivateKey)
+		if !ok {
+			return nil, nil, fmt.Errorf("certificate (%s) does not contain an RSA private key", certPath)
+		}
+		return cert, rsaKey, nil
+	}
+
+	cert, rsaKey, err := decodePEMCertificateAndKey(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s as PKCS#12 or PEM: %v", certPath, err)
+	}
+	return cert, rsaKey, nil
+}
+
+// decodePEMCertificateAndKey parses a PEM file containing an x509 certificate
+// and its unencrypted RSA private key, in either order.
+func decodePEMCertificateAndKey(data []byte) (*x509.Certificate, *rsa.PrivateKey, error) {
+	var cert *x509.Certificate
+	var key *rsa.PrivateKey
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case "CERTIFICATE":
+			parsed, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse certificate: %v", err)
+			}
+			cert = parsed
+		case "RSA PRIVATE KEY", "PRIVATE KEY":
+			parsed, err := parsePrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse private key: %v", err)
+			}
+			key = parsed
+		}
+	}
+
+	if cert == nil || key == nil {
+		return nil, nil, errors.New("PEM file must contain both a CERTIFICATE and an RSA PRIVATE KEY block")
+	}
+	return cert, key, nil
+}
+
+func parsePrivateKey(der []byte) (*rsa.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+//====================================================================================
+// managed-identity credential provider
+
+const (
+	imdsTokenEndpoint  = "http://169.254.169.254/metadata/identity/oauth2/token"
+	imdsAPIVersion     = "2018-02-01"
+	imdsRequestTimeout = 5 * time.Second
+)
+
+// ManagedIdentityCredentialProvider acquires a token for the identity
+// assigned to the VM or App Service instance azcopy is running on.
+type ManagedIdentityCredentialProvider struct {
+	// ClientID optionally selects a user-assigned managed identity; left
+	// empty, the system-assigned identity is used.
+	ClientID string
+	// Resource is the storage resource ID to request the token for, i.e.
+	// cloudInfo(cloud).StorageResource; it must be set to the caller's
+	// cloud, not assumed to be AzurePublic's.
+	Resource string
+}
+
+func NewManagedIdentityCredentialProvider(resource string) *ManagedIdentityCredentialProvider {
+	return &ManagedIdentityCredentialProvider{Resource: resource}
+}
+
+func (p *ManagedIdentityCredentialProvider) Name() string {
+	return "managed-identity"
+}
+
+func (p *ManagedIdentityCredentialProvider) GetToken() (*OAuthTokenInfo, error) {
+	if endpoint, secret := os.Getenv("MSI_ENDPOINT"), os.Getenv("MSI_SECRET"); endpoint != "" && secret != "" {
+		return p.getTokenFromAppService(endpoint, secret)
+	}
+	return p.getTokenFromIMDS()
+}
+
+func (p *ManagedIdentityCredentialProvider) getTokenFromIMDS() (*OAuthTokenInfo, error) {
+	query := url.Values{}
+	query.Set("api-version", imdsAPIVersion)
+	query.Set("resource", p.Resource)
+	if p.ClientID != "" {
+		query.Set("client_id", p.ClientID)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, imdsTokenEndpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata", "true")
+
+	return p.requestToken(req)
+}
+
+func (p *ManagedIdentityCredentialProvider) getTokenFromAppService(endpoint, secret string) (*OAuthTokenInfo, error) {
+	query := url.Values{}
+	query.Set("api-version", "2017-09-01")
+	query.Set("resource", p.Resource)
+	if p.ClientID != "" {
+		query.Set("clientid", p.ClientID)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Secret", secret)
+
+	return p.requestToken(req)
+}
+
+func (p *ManagedIdentityCredentialProvider) requestToken(req *http.Request) (*OAuthTokenInfo, error) {
+	client := &http.Client{Timeout: imdsRequestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach managed identity endpoint: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read managed identity response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("managed identity endpoint returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var token adal.Token
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("failed to parse managed identity response: %v", err)
+	}
+
+	return &OAuthTokenInfo{Token: token}, nil
+}
+
+//====================================================================================
+// Azure CLI credential provider
+
+// AzureCLICredentialProvider shells out to `az account get-access-token` to
+// reuse whatever session the user already has via the Azure CLI.
+type AzureCLICredentialProvider struct {
+	// Resource is the storage resource ID to request the token for, i.e.
+	// cloudInfo(cloud).StorageResource; it must be set to the caller's
+	// cloud, not assumed to be AzurePublic's.
+	Resource string
+}
+
+func NewAzureCLICredentialProvider(resource string) *AzureCLICredentialProvider {
+	return &AzureCLICredentialProvider{Resource: resource}
+}
+
+func (p *AzureCLICredentialProvider) Name() string {
+	return "azure-cli"
+}
+
+func (p *AzureCLICredentialProvider) GetToken() (*OAuthTokenInfo, error) {
+	cmd := exec.Command("az", "account", "get-access-token", "--resource", p.Resource, "-o", "json")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to invoke az CLI (is it installed and logged in?): %v", err)
+	}
+
+	var parsed struct {
+		AccessToken string `json:"accessToken"`
+		ExpiresOn   string `json:"expiresOn"`
+		TokenType   string `json:"tokenType"`
+		Tenant      string `json:"tenant"`
+	}
+	if err := json.Unmarshal(out, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse az CLI output: %v", err)
+	}
+	if parsed.AccessToken == "" {
+		return nil, errors.New("az CLI returned no access token")
+	}
+
+	// az CLI reports expiresOn as a local-time timestamp, not the unix epoch
+	// seconds adal.Token expects; convert it so GetCachedTokenInfo's expiry
+	// check can tell this token is (or isn't) actually expired instead of
+	// treating the zero value as already-expired.
+	expiresOn, err := time.ParseInLocation("2006-01-02 15:04:05.000000", parsed.ExpiresOn, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse az CLI token expiry %q: %v", parsed.ExpiresOn, err)
+	}
+
+	return &OAuthTokenInfo{
+		Token: adal.Token{
+			AccessToken: parsed.AccessToken,
+			Type:        parsed.TokenType,
+			Resource:    p.Resource,
+			ExpiresIn:   json.Number(strconv.FormatInt(int64(time.Until(expiresOn).Seconds()), 10)),
+			ExpiresOn:   json.Number(strconv.FormatInt(expiresOn.Unix(), 10)),
+		},
+		Tenant: parsed.Tenant,
+	}, nil
+}
+
+//====================================================================================
+// environment-variable credential provider
+
+// NewEnvironmentCredentialProvider reads AZCOPY_AUTO_LOGIN_TYPE-independent,
+// always-available environment variables and returns whichever credential
+// provider they configure: a client secret (AZURE_TENANT_ID, AZURE_CLIENT_ID,
+// AZURE_CLIENT_SECRET) or a client certificate (...CLIENT_CERTIFICATE_PATH,
+// optionally ...CLIENT_CERTIFICATE_PASSWORD). resource is the cloud's storage
+// resource ID (cloudInfo(cloud).StorageResource).
+func NewEnvironmentCredentialProvider(activeDirectoryEndpoint, resource string) CredentialProvider {
+	tenantID := os.Getenv("AZURE_TENANT_ID")
+	clientID := os.Getenv("AZURE_CLIENT_ID")
+
+	if certPath := os.Getenv("AZURE_CLIENT_CERTIFICATE_PATH"); certPath != "" {
+		return &ClientCertificateCredentialProvider{
+			TenantID:                tenantID,
+			ClientID:                clientID,
+			CertificatePath:         certPath,
+			CertificatePassword:     os.Getenv("AZURE_CLIENT_CERTIFICATE_PASSWORD"),
+			ActiveDirectoryEndpoint: activeDirectoryEndpoint,
+			Resource:                resource,
+		}
+	}
+
+	return &ClientSecretCredentialProvider{
+		TenantID:                tenantID,
+		ClientID:                clientID,
+		ClientSecret:            os.Getenv("AZURE_CLIENT_SECRET"),
+		ActiveDirectoryEndpoint: activeDirectoryEndpoint,
+		Resource:                resource,
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestManagedIdentityGetTokenUsesAppServiceWhenConfigured pins MSI_ENDPOINT
+// and MSI_SECRET to a fake App Service endpoint. If GetToken picked the IMDS
+// branch instead, this would fail trying to reach the (unreachable in a test
+// environment) link-local IMDS address rather than returning the token below.
+func TestManagedIdentityGetTokenUsesAppServiceWhenConfigured(t *testing.T) {
+	var gotSecret string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSecret = r.Header.Get("Secret")
+		fmt.Fprint(w, `{"access_token":"app-service-token","resource":"`+Resource+`"}`)
+	}))
+	defer server.Close()
+
+	t.Setenv("MSI_ENDPOINT", server.URL)
+	t.Setenv("MSI_SECRET", "the-secret")
+
+	p := NewManagedIdentityCredentialProvider(Resource)
+	info, err := p.GetToken()
+	if err != nil {
+		t.Fatalf("GetToken failed: %v", err)
+	}
+	if info.AccessToken != "app-service-token" {
+		t.Fatalf("got access token %q, want %q", info.AccessToken, "app-service-token")
+	}
+	if gotSecret != "the-secret" {
+		t.Fatalf("App Service request carried Secret header %q, want %q", gotSecret, "the-secret")
+	}
+}
+
+func TestManagedIdentityGetTokenFromAppServicePassesClientID(t *testing.T) {
+	var gotClientID string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotClientID = r.URL.Query().Get("clientid")
+		fmt.Fprint(w, `{"access_token":"token","resource":"`+Resource+`"}`)
+	}))
+	defer server.Close()
+
+	p := &ManagedIdentityCredentialProvider{ClientID: "user-assigned-id"}
+	if _, err := p.getTokenFromAppService(server.URL, "secret"); err != nil {
+		t.Fatalf("getTokenFromAppService failed: %v", err)
+	}
+	if gotClientID != "user-assigned-id" {
+		t.Fatalf("got clientid query param %q, want %q", gotClientID, "user-assigned-id")
+	}
+}
+
+func TestManagedIdentityRequestTokenSurfacesNonOKStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "identity not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	p := NewManagedIdentityCredentialProvider(Resource)
+	if _, err := p.getTokenFromAppService(server.URL, "secret"); err == nil {
+		t.Fatal("expected an error for a non-200 managed identity response")
+	}
+}
+
+func TestManagedIdentityRequestTokenRejectsMalformedJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `not json`)
+	}))
+	defer server.Close()
+
+	p := NewManagedIdentityCredentialProvider(Resource)
+	if _, err := p.getTokenFromAppService(server.URL, "secret"); err == nil {
+		t.Fatal("expected an error for a malformed managed identity response")
+	}
+}
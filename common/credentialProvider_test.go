@@ -0,0 +1,195 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeCredentialProvider struct {
+	name  string
+	token *OAuthTokenInfo
+	err   error
+}
+
+func (f *fakeCredentialProvider) Name() string { return f.name }
+
+func (f *fakeCredentialProvider) GetToken() (*OAuthTokenInfo, error) {
+	return f.token, f.err
+}
+
+// TestNewDefaultCredentialChainScopesProvidersToResource guards against the
+// default chain quietly reintroducing AzurePublic's storage resource for
+// sovereign-cloud callers: every provider it builds must carry the resource
+// NewDefaultCredentialChain was given, not the package-level Resource constant.
+func TestNewDefaultCredentialChainScopesProvidersToResource(t *testing.T) {
+	const govResource = "https://storage.azure.us"
+	chain := NewDefaultCredentialChain("https://login.microsoftonline.us", govResource)
+
+	if len(chain.providers) != 3 {
+		t.Fatalf("expected 3 providers in the default chain, got %d", len(chain.providers))
+	}
+
+	env, ok := chain.providers[0].(*ClientSecretCredentialProvider)
+	if !ok {
+		t.Fatalf("expected providers[0] to default to *ClientSecretCredentialProvider, got %T", chain.providers[0])
+	}
+	if env.Resource != govResource {
+		t.Errorf("environment provider got resource %q, want %q", env.Resource, govResource)
+	}
+
+	mi, ok := chain.providers[1].(*ManagedIdentityCredentialProvider)
+	if !ok {
+		t.Fatalf("expected providers[1] to be *ManagedIdentityCredentialProvider, got %T", chain.providers[1])
+	}
+	if mi.Resource != govResource {
+		t.Errorf("managed identity provider got resource %q, want %q", mi.Resource, govResource)
+	}
+
+	cli, ok := chain.providers[2].(*AzureCLICredentialProvider)
+	if !ok {
+		t.Fatalf("expected providers[2] to be *AzureCLICredentialProvider, got %T", chain.providers[2])
+	}
+	if cli.Resource != govResource {
+		t.Errorf("azure CLI provider got resource %q, want %q", cli.Resource, govResource)
+	}
+}
+
+func TestChainedCredentialProviderReturnsFirstSuccess(t *testing.T) {
+	want := &OAuthTokenInfo{Tenant: "expected-tenant"}
+	chain := NewChainedCredentialProvider(
+		&fakeCredentialProvider{name: "first", err: errors.New("first failed")},
+		&fakeCredentialProvider{name: "second", token: want},
+		&fakeCredentialProvider{name: "third", err: errors.New("should not be reached")},
+	)
+
+	got, err := chain.GetToken()
+	if err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	if got != want {
+		t.Fatalf("expected the second provider's token, got %v", got)
+	}
+}
+
+func TestChainedCredentialProviderCombinesErrorsWhenAllFail(t *testing.T) {
+	chain := NewChainedCredentialProvider(
+		&fakeCredentialProvider{name: "first", err: errors.New("first failed")},
+		&fakeCredentialProvider{name: "second", err: errors.New("second failed")},
+	)
+
+	_, err := chain.GetToken()
+	if err == nil {
+		t.Fatal("expected an error when every provider in the chain fails")
+	}
+	for _, want := range []string{"first", "first failed", "second", "second failed"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("combined error %q does not mention %q", err.Error(), want)
+		}
+	}
+}
+
+// generateSelfSignedCert returns a PEM-encoded self-signed certificate and
+// its RSA private key, for exercising decodePEMCertificateAndKey without a
+// real service principal certificate on disk.
+func generateSelfSignedCert(t *testing.T) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "azcopy-test"},
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Unix(0, 0).Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create test certificate: %v", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return certPEM, keyPEM
+}
+
+func TestLoadCertificateAndKeyFromPEM(t *testing.T) {
+	certPEM, keyPEM := generateSelfSignedCert(t)
+
+	for _, order := range []struct {
+		name string
+		data []byte
+	}{
+		{"cert then key", append(append([]byte{}, certPEM...), keyPEM...)},
+		{"key then cert", append(append([]byte{}, keyPEM...), certPEM...)},
+	} {
+		t.Run(order.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cert.pem")
+			if err := os.WriteFile(path, order.data, 0600); err != nil {
+				t.Fatalf("failed to write test cert file: %v", err)
+			}
+
+			cert, key, err := loadCertificateAndKey(path, "")
+			if err != nil {
+				t.Fatalf("loadCertificateAndKey failed: %v", err)
+			}
+			if cert.Subject.CommonName != "azcopy-test" {
+				t.Fatalf("unexpected certificate subject: %v", cert.Subject.CommonName)
+			}
+			if key == nil {
+				t.Fatal("expected a non-nil RSA private key")
+			}
+		})
+	}
+}
+
+func TestLoadCertificateAndKeyRejectsIncompletePEM(t *testing.T) {
+	certPEM, _ := generateSelfSignedCert(t)
+	path := filepath.Join(t.TempDir(), "cert-only.pem")
+	if err := os.WriteFile(path, certPEM, 0600); err != nil {
+		t.Fatalf("failed to write test cert file: %v", err)
+	}
+
+	if _, _, err := loadCertificateAndKey(path, ""); err == nil {
+		t.Fatal("expected an error when the PEM file has no private key")
+	}
+}
+
+func TestLoadCertificateAndKeyRejectsMissingFile(t *testing.T) {
+	if _, _, err := loadCertificateAndKey(fmt.Sprintf("%s/does-not-exist.pem", t.TempDir()), ""); err == nil {
+		t.Fatal("expected an error for a missing certificate file")
+	}
+}
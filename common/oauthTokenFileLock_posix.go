@@ -0,0 +1,52 @@
+//go:build linux || darwin
+// +build linux darwin
+
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import "golang.org/x/sys/unix"
+
+// fileLock holds an exclusive, advisory flock() on the token cache's lock
+// file for the lifetime of a read-modify-write of the token cache, so that
+// two azcopy processes refreshing concurrently can't clobber each other.
+type fileLock struct {
+	fd int
+}
+
+// lockFile blocks until it holds an exclusive lock on lockPath.
+func lockFile(lockPath string) (*fileLock, error) {
+	fd, err := unix.Open(lockPath, unix.O_CREAT|unix.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(fd, unix.LOCK_EX); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &fileLock{fd: fd}, nil
+}
+
+// Unlock releases the lock and closes the underlying file descriptor.
+func (l *fileLock) Unlock() error {
+	defer unix.Close(l.fd)
+	return unix.Flock(l.fd, unix.LOCK_UN)
+}
@@ -0,0 +1,68 @@
+//go:build windows
+// +build windows
+
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// fileLock holds an exclusive LockFileEx lock on the token cache's lock file
+// for the lifetime of a read-modify-write of the token cache, so that two
+// azcopy processes refreshing concurrently can't clobber each other.
+type fileLock struct {
+	handle syscall.Handle
+}
+
+// lockFile blocks until it holds an exclusive lock on lockPath.
+func lockFile(lockPath string) (*fileLock, error) {
+	pathp, err := syscall.UTF16PtrFromString(lockPath)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := syscall.CreateFile(pathp,
+		syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+		0, nil,
+		syscall.OPEN_ALWAYS,
+		syscall.FILE_ATTRIBUTE_NORMAL,
+		0)
+	if err != nil {
+		return nil, err
+	}
+
+	overlapped := new(windows.Overlapped)
+	if err := windows.LockFileEx(windows.Handle(handle), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, overlapped); err != nil {
+		syscall.CloseHandle(handle)
+		return nil, err
+	}
+	return &fileLock{handle: handle}, nil
+}
+
+// Unlock releases the lock and closes the underlying file handle.
+func (l *fileLock) Unlock() error {
+	defer syscall.CloseHandle(l.handle)
+	overlapped := new(windows.Overlapped)
+	return windows.UnlockFileEx(windows.Handle(l.handle), 0, 1, 0, overlapped)
+}
@@ -24,11 +24,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"os"
-	"path"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -53,27 +49,47 @@ const defaultTokenFileName = "AccessToken.json"
 type UserOAuthTokenManager struct {
 	oauthClient        *http.Client
 	userTokenCachePath string
+	cloud              AzureCloud
+	tokenCache         TokenCache
 }
 
-// NewUserOAuthTokenManagerInstance creates a token manager instance.
+// NewUserOAuthTokenManagerInstance creates a token manager instance for the
+// given cloud. The TokenCache backend defaults to the on-disk file cache
+// rooted at userTokenCachePath, but can be overridden with AZCOPY_TOKEN_CACHE
+// (see newTokenCacheFromEnv).
 // TODO: userTokenCachePath can be optimized to cache manager
-func NewUserOAuthTokenManagerInstance(userTokenCachePath string) *UserOAuthTokenManager {
+func NewUserOAuthTokenManagerInstance(userTokenCachePath string, cloud AzureCloud) *UserOAuthTokenManager {
 	return &UserOAuthTokenManager{
 		oauthClient:        &http.Client{},
 		userTokenCachePath: userTokenCachePath,
+		cloud:              cloud,
+		tokenCache:         newTokenCacheFromEnv(userTokenCachePath),
 	}
 }
 
 // LoginWithDefaultADEndpoint interactively logins in with specified tenantID, persist indicates whether to
-// cache the token on local disk.
+// cache the token on local disk. The AD endpoint used is the one registered for uotm's cloud.
 func (uotm *UserOAuthTokenManager) LoginWithDefaultADEndpoint(tenantID string, persist bool) (*OAuthTokenInfo, error) {
-	return uotm.LoginWithADEndpoint(tenantID, DefaultActiveDirectoryEndpoint, persist)
+	return uotm.LoginWithADEndpoint(tenantID, cloudInfo(uotm.cloud).ActiveDirectoryEndpoint, persist)
 }
 
 // LoginWithADEndpoint interactively logins in with specified tenantID and activeDirectoryEndpoint, persist indicates whether to
 // cache the token on local disk.
 func (uotm *UserOAuthTokenManager) LoginWithADEndpoint(tenantID, activeDirectoryEndpoint string, persist bool) (*OAuthTokenInfo, error) {
-	if !gEncryptionUtil.IsEncryptionRobust() {
+	return uotm.loginWithADEndpointForResource(tenantID, activeDirectoryEndpoint, cloudInfo(uotm.cloud).StorageResource, persist)
+}
+
+// loginWithADEndpointForResource is LoginWithADEndpoint generalized over the
+// resource the device-code token is scoped to, so callers that need a token
+// for something other than Azure Storage (e.g. DiscoverTenants, which needs
+// an ARM-scoped token) can reuse the same interactive flow.
+func (uotm *UserOAuthTokenManager) loginWithADEndpointForResource(tenantID, activeDirectoryEndpoint, resource string, persist bool) (*OAuthTokenInfo, error) {
+	// The keyring backend stores secrets in a user-scoped encrypted store and
+	// the memory and environment backends never write the token to a file,
+	// so the ACL-robustness caveat below, which is specific to the plain 0600
+	// token file, doesn't apply to them.
+	_, usesFileCache := uotm.tokenCache.(*fileTokenCache)
+	if usesFileCache && !gEncryptionUtil.IsEncryptionRobust() {
 		fmt.Println("In non-Windows platform, Azcopy relies on ACL to protect unencrypted access token. " +
 			"This could be unsafe if ACL is compromised, e.g. hard disk is plugged out and used in another computer. " +
 			"Please acknowledge the potential risk caused by ACL before continuing. " +
@@ -93,12 +109,18 @@ func (uotm *UserOAuthTokenManager) LoginWithADEndpoint(tenantID, activeDirectory
 		return nil, err
 	}
 
+	cloud := cloudInfo(uotm.cloud)
+	if cloud.ApplicationID == "" {
+		return nil, fmt.Errorf("no application ID is registered for cloud %q; set %s to the application ID "+
+			"registered for azcopy in that cloud before logging in", uotm.cloud, azCopyApplicationIDEnvVar)
+	}
+
 	// Acquire the device code
 	deviceCode, err := adal.InitiateDeviceAuth(
 		uotm.oauthClient,
 		*oauthConfig,
-		ApplicationID,
-		Resource)
+		cloud.ApplicationID,
+		resource)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to login due to error: %s", err.Error())
 	}
@@ -116,6 +138,7 @@ func (uotm *UserOAuthTokenManager) LoginWithADEndpoint(tenantID, activeDirectory
 		Token:                   *token,
 		Tenant:                  tenantID,
 		ActiveDirectoryEndpoint: activeDirectoryEndpoint,
+		Environment:             uotm.cloud,
 	}
 	if persist {
 		err = uotm.saveTokenInfo(oAuthTokenInfo)
@@ -127,6 +150,79 @@ func (uotm *UserOAuthTokenManager) LoginWithADEndpoint(tenantID, activeDirectory
 	return &oAuthTokenInfo, nil
 }
 
+// LoginOptions carries the extra, mode-specific fields LoginWithAuthMode
+// needs to build a CredentialProvider - e.g. the values behind --tenant-id,
+// --application-id, --client-secret, --certificate-path and
+// --certificate-password once those are parsed out of the command line.
+type LoginOptions struct {
+	TenantID                string
+	ActiveDirectoryEndpoint string
+	ClientID                string
+	ClientSecret            string
+	CertificatePath         string
+	CertificatePassword     string
+}
+
+// LoginWithAuthMode acquires a token using the CredentialProvider(s) selected
+// by mode (AuthModeAuto walks the default unattended chain; any other mode
+// uses the single matching provider), and persists the token it returns to
+// the token cache. Which provider produced the token is not recorded; once a
+// cached token without a refresh token expires, GetCachedTokenInfo fails and
+// login has to be run again.
+func (uotm *UserOAuthTokenManager) LoginWithAuthMode(mode AuthMode, opts LoginOptions) (*OAuthTokenInfo, error) {
+	tenantID := opts.TenantID
+	if tenantID == "" {
+		tenantID = DefaultTenantID
+	}
+	activeDirectoryEndpoint := opts.ActiveDirectoryEndpoint
+	resource := cloudInfo(uotm.cloud).StorageResource
+
+	var provider CredentialProvider
+	switch mode {
+	case AuthModeAuto, "":
+		provider = NewDefaultCredentialChain(activeDirectoryEndpoint, resource)
+	case AuthModeDevice:
+		provider = NewDeviceCodeCredentialProvider(uotm, tenantID, activeDirectoryEndpoint)
+	case AuthModeClientSecret:
+		provider = &ClientSecretCredentialProvider{
+			TenantID:                tenantID,
+			ClientID:                opts.ClientID,
+			ClientSecret:            opts.ClientSecret,
+			ActiveDirectoryEndpoint: activeDirectoryEndpoint,
+			Resource:                resource,
+		}
+	case AuthModeClientCertificate:
+		provider = &ClientCertificateCredentialProvider{
+			TenantID:                tenantID,
+			ClientID:                opts.ClientID,
+			CertificatePath:         opts.CertificatePath,
+			CertificatePassword:     opts.CertificatePassword,
+			ActiveDirectoryEndpoint: activeDirectoryEndpoint,
+			Resource:                resource,
+		}
+	case AuthModeManagedIdentity:
+		provider = NewManagedIdentityCredentialProvider(resource)
+	case AuthModeAzureCLI:
+		provider = NewAzureCLICredentialProvider(resource)
+	case AuthModeEnvironment:
+		provider = NewEnvironmentCredentialProvider(activeDirectoryEndpoint, resource)
+	default:
+		return nil, fmt.Errorf("unrecognized --auth-mode %q", mode)
+	}
+
+	tokenInfo, err := provider.GetToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to login with auth-mode %q: %v", mode, err)
+	}
+	tokenInfo.Environment = uotm.cloud
+
+	if err := uotm.saveTokenInfo(*tokenInfo); err != nil {
+		return nil, fmt.Errorf("failed to login during persisting token to local, due to error: %s", err.Error())
+	}
+
+	return tokenInfo, nil
+}
+
 // GetCachedTokenInfo get a fresh token from local disk cache.
 // If access token is expired, it will refresh the token.
 // If refresh token is expired, the method will fail and return failure reason.
@@ -141,6 +237,26 @@ func (uotm *UserOAuthTokenManager) GetCachedTokenInfo() (*OAuthTokenInfo, error)
 		return nil, fmt.Errorf("Get cached token failed due to error: %v", err.Error())
 	}
 
+	// The unattended CredentialProviders (managed identity, client
+	// secret/certificate, az CLI) authenticate via a client-credential-style
+	// grant and never receive a refresh token, but EnsureFresh below can
+	// only renew via the refresh_token grant. If the access token is still
+	// within its fresh window there's nothing to do; if it's not, fail fast
+	// with an actionable error instead of letting EnsureFresh discover the
+	// missing refresh token via a doomed HTTP call.
+	if tokenInfo.RefreshToken == "" && time.Until(tokenInfo.Token.Expires()) <= DefaultTokenExpiryWithinThreshold {
+		return nil, fmt.Errorf("cached token for tenant %q has no refresh token and is expired or expiring soon; "+
+			"re-run login with the same --auth-mode to mint a new one", tokenInfo.Tenant)
+	}
+	if tokenInfo.RefreshToken == "" {
+		return tokenInfo, nil
+	}
+
+	// Refresh against the cloud the token was minted in, not uotm's configured
+	// cloud, so a token minted in e.g. Gov cloud isn't refreshed against the
+	// public login endpoint.
+	cloud := cloudInfo(tokenInfo.Environment)
+
 	oauthConfig, err := adal.NewOAuthConfig(tokenInfo.ActiveDirectoryEndpoint, tokenInfo.Tenant)
 	if err != nil {
 		return nil, err
@@ -148,13 +264,18 @@ func (uotm *UserOAuthTokenManager) GetCachedTokenInfo() (*OAuthTokenInfo, error)
 
 	spt, err := adal.NewServicePrincipalTokenFromManualToken(
 		*oauthConfig,
-		ApplicationID,
-		Resource,
+		cloud.ApplicationID,
+		cloud.StorageResource,
 		tokenInfo.Token)
 	if err != nil {
 		return nil, fmt.Errorf("Get cached token failed to due to error: %v", err.Error())
 	}
 
+	// Write through to the token cache on every background refresh adal performs, not
+	// just the one we persist below, so hours-long copy jobs don't
+	// accumulate a refresh token that only lives in this process' memory.
+	spt.SetRefreshCallbacks([]adal.TokenRefreshCallback{uotm.persistRefreshed(tokenInfo.Tenant, tokenInfo.ActiveDirectoryEndpoint, tokenInfo.Environment)})
+
 	// Ensure at least 10 minutes fresh time.
 	spt.SetRefreshWithin(DefaultTokenExpiryWithinThreshold)
 	spt.SetAutoRefresh(true)
@@ -171,6 +292,7 @@ func (uotm *UserOAuthTokenManager) GetCachedTokenInfo() (*OAuthTokenInfo, error)
 			Token:                   freshToken,
 			Tenant:                  tokenInfo.Tenant,
 			ActiveDirectoryEndpoint: tokenInfo.ActiveDirectoryEndpoint,
+			Environment:             tokenInfo.Environment,
 		}
 		if err := uotm.saveTokenInfo(tokenInfoToPersist); err != nil {
 			return nil, err
@@ -183,70 +305,25 @@ func (uotm *UserOAuthTokenManager) GetCachedTokenInfo() (*OAuthTokenInfo, error)
 
 // HasCachedToken returns if there is cached token in token manager.
 func (uotm *UserOAuthTokenManager) HasCachedToken() bool {
-	fmt.Println("uotm", "HasCachedToken", uotm.tokenFilePath())
-	if _, err := os.Stat(uotm.tokenFilePath()); err == nil {
-		return true
-	}
-	return false
+	return uotm.tokenCache.Exists()
 }
 
 // RemoveCachedToken delete all the cached token.
 func (uotm *UserOAuthTokenManager) RemoveCachedToken() error {
-	tokenFilePath := uotm.tokenFilePath()
-
-	if _, err := os.Stat(tokenFilePath); err == nil {
-		// Cached token file existed
-		err = os.Remove(tokenFilePath)
-		if err != nil { // remove failed
-			return fmt.Errorf("failed to remove cached token file with path: %s, due to error: %v", tokenFilePath, err.Error())
-		}
-
-		// remove succeeded
-	} else {
-		if !os.IsNotExist(err) { // Failed to stat cached token file
-			return fmt.Errorf("fail to stat cached token file with path: %s, due to error: %v", tokenFilePath, err.Error())
-		}
-
-		//token doesn't exist
+	if !uotm.tokenCache.Exists() {
 		fmt.Println("no cached token found for current user.")
+		return nil
 	}
-
-	return nil
-}
-
-func (uotm *UserOAuthTokenManager) tokenFilePath() string {
-	return path.Join(uotm.userTokenCachePath, "/", defaultTokenFileName)
+	return uotm.tokenCache.Delete()
 }
 
 func (uotm *UserOAuthTokenManager) loadTokenInfo() (*OAuthTokenInfo, error) {
-	token, err := uotm.loadTokenInfoInternal(uotm.tokenFilePath())
+	b, err := uotm.tokenCache.Load()
 	if err != nil {
 		return nil, fmt.Errorf("failed to load token from cache: %v", err)
 	}
 
-	return token, nil
-}
-
-// LoadToken restores a Token object from a file located at 'path'.
-func (uotm *UserOAuthTokenManager) loadTokenInfoInternal(path string) (*OAuthTokenInfo, error) {
-	b, err := ioutil.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read token file (%s) while loading token: %v", path, err)
-	}
-
-	// var token OAuthTokenInfo
-
-	// dec := json.NewDecoder(file)
-	// if err = dec.Decode(&token); err != nil {
-	// 	return nil, fmt.Errorf("failed to decode contents of file (%s) into Token representation: %v", path, err)
-	// }
-
-	decryptedB, err := gEncryptionUtil.Decrypt(b)
-	if err != nil {
-		return nil, fmt.Errorf("fail to decrypt bytes: %s", err.Error())
-	}
-
-	token, err := JSONToTokenInfo(decryptedB)
+	token, err := JSONToTokenInfo(b)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal token, due to error: %s", err.Error())
 	}
@@ -254,76 +331,40 @@ func (uotm *UserOAuthTokenManager) loadTokenInfoInternal(path string) (*OAuthTok
 	return token, nil
 }
 
-func (uotm *UserOAuthTokenManager) saveTokenInfo(token OAuthTokenInfo) error {
-	err := uotm.saveTokenInfoInternal(uotm.tokenFilePath(), 0600, token) // Save token with read/write permissions for the owner of the file.
-	if err != nil {
-		return fmt.Errorf("failed to save token to cache: %v", err)
+// persistRefreshed returns an adal.TokenRefreshCallback that writes a
+// refreshed token straight through to uotm's token cache, keyed to the tenant,
+// AD endpoint and cloud the token was originally acquired for.
+func (uotm *UserOAuthTokenManager) persistRefreshed(tenant, activeDirectoryEndpoint string, environment AzureCloud) adal.TokenRefreshCallback {
+	return func(token adal.Token) error {
+		return uotm.saveTokenInfo(OAuthTokenInfo{
+			Token:                   token,
+			Tenant:                  tenant,
+			ActiveDirectoryEndpoint: activeDirectoryEndpoint,
+			Environment:             environment,
+		})
 	}
-	return nil
 }
 
-// saveTokenInternal persists an oauth token at the given location on disk.
-// It moves the new file into place so it can safely be used to replace an existing file
-// that maybe accessed by multiple processes.
-// get from adal and optimzied to involve more token info.
-func (uotm *UserOAuthTokenManager) saveTokenInfoInternal(path string, mode os.FileMode, token OAuthTokenInfo) error {
-	dir := filepath.Dir(path)
-	err := os.MkdirAll(dir, os.ModePerm)
-	if err != nil {
-		return fmt.Errorf("failed to create directory (%s) to store token in: %v", dir, err)
-	}
-
-	newFile, err := ioutil.TempFile(dir, "token")
-	if err != nil {
-		return fmt.Errorf("failed to create the temp file to write the token: %v", err)
-	}
-	tempPath := newFile.Name()
-
-	json, err := token.ToJSON()
+func (uotm *UserOAuthTokenManager) saveTokenInfo(token OAuthTokenInfo) error {
+	b, err := token.ToJSON()
 	if err != nil {
 		return fmt.Errorf("failed to marshal token, due to error: %s", err.Error())
 	}
 
-	b, err := gEncryptionUtil.Encrypt(json)
-	if err != nil {
-		return fmt.Errorf("failed to encrypt token: %v", err)
-	}
-
-	if _, err = newFile.Write(b); err != nil {
-		return fmt.Errorf("failed to encode token to file (%s) while saving token: %v", tempPath, err)
-	}
-
-	// if err := json.NewEncoder(newFile).Encode(token); err != nil {
-	// 	return fmt.Errorf("failed to encode token to file (%s) while saving token: %v", tempPath, err)
-	// }
-	if err := newFile.Close(); err != nil {
-		return fmt.Errorf("failed to close temp file %s: %v", tempPath, err)
-	}
-
-	// Atomic replace to avoid multi-writer file corruptions
-	if err := os.Rename(tempPath, path); err != nil {
-		return fmt.Errorf("failed to move temporary token to desired output location. src=%s dst=%s: %v", tempPath, path, err)
-	}
-	if err := os.Chmod(path, mode); err != nil {
-		return fmt.Errorf("failed to chmod the token file %s: %v", path, err)
+	if err := uotm.tokenCache.Save(b); err != nil {
+		return fmt.Errorf("failed to save token to cache: %v", err)
 	}
 	return nil
 }
 
-// func (uotm *UserOAuthTokenManager) encrypt(token adal.Token) (string, error) {
-// 	panic("not implemented")
-// }
-// func (uotm *UserOAuthTokenManager) decrypt(string) (adal.Token, error) {
-// 	panic("not implemented")
-// }
-
 //====================================================================================
 
 // OAuthTokenInfo contains info necessary for refresh OAuth credentials.
 type OAuthTokenInfo struct {
 	adal.Token
-	Tenant                  string `json:"_tenant"`
-	ActiveDirectoryEndpoint string `json:"_ad_endpoint"`
+	Tenant                  string     `json:"_tenant"`
+	ActiveDirectoryEndpoint string     `json:"_ad_endpoint"`
+	Environment             AzureCloud `json:"_environment"`
 }
 
 // IsEmpty returns if current OAuthTokenInfo is empty and doesn't contain any useful info.
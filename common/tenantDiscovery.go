@@ -0,0 +1,134 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// commonTenantID is the special AAD tenant that lets a user authenticate
+// before azcopy knows which of their tenants actually holds the storage
+// account they want to work with.
+const commonTenantID = "common"
+
+// SubscriptionInfo is one subscription discovered under a tenant.
+type SubscriptionInfo struct {
+	ID          string `json:"subscriptionId"`
+	DisplayName string `json:"displayName"`
+}
+
+// TenantInfo is one AAD tenant discovered for the logged-in user, along with
+// the subscriptions azcopy found under it.
+type TenantInfo struct {
+	ID            string `json:"tenantId"`
+	DisplayName   string `json:"displayName"`
+	Subscriptions []SubscriptionInfo
+}
+
+// DiscoverTenants logs in against the "common" tenant and then asks ARM which
+// tenants and subscriptions the resulting token can see, so that callers
+// (e.g. `azcopy login --list-tenants`) can offer the user a tenant to pick
+// rather than requiring --tenant-id up front.
+func (uotm *UserOAuthTokenManager) DiscoverTenants() ([]TenantInfo, error) {
+	cloud := cloudInfo(uotm.cloud)
+
+	// ARM only accepts a token whose audience is its own management
+	// endpoint, not the storage resource LoginWithADEndpoint would normally
+	// request, so this needs its own login scoped to ManagementEndpoint.
+	tokenInfo, err := uotm.loginWithADEndpointForResource(commonTenantID, cloud.ActiveDirectoryEndpoint, cloud.ManagementEndpoint, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to login against the common tenant: %v", err)
+	}
+
+	rawTenants, err := uotm.armGet("/tenants?api-version=2016-06-01", tokenInfo.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenants: %v", err)
+	}
+	var tenants struct {
+		Value []TenantInfo `json:"value"`
+	}
+	if err := json.Unmarshal(rawTenants, &tenants); err != nil {
+		return nil, fmt.Errorf("failed to parse tenant list: %v", err)
+	}
+
+	rawSubs, err := uotm.armGet("/subscriptions?api-version=2016-06-01", tokenInfo.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subscriptions: %v", err)
+	}
+	var subs struct {
+		Value []taggedSubscriptionInfo `json:"value"`
+	}
+	if err := json.Unmarshal(rawSubs, &subs); err != nil {
+		return nil, fmt.Errorf("failed to parse subscription list: %v", err)
+	}
+
+	return mergeSubscriptionsIntoTenants(tenants.Value, subs.Value), nil
+}
+
+// taggedSubscriptionInfo is how ARM's /subscriptions response associates a
+// subscription with the tenant it belongs to.
+type taggedSubscriptionInfo struct {
+	SubscriptionInfo
+	TenantID string `json:"tenantId"`
+}
+
+// mergeSubscriptionsIntoTenants attaches each subscription to the tenant it
+// belongs to, split out from DiscoverTenants so it can be unit tested without
+// an ARM round-trip. tenants is returned with Subscriptions populated; the
+// input slices are otherwise left untouched.
+func mergeSubscriptionsIntoTenants(tenants []TenantInfo, subs []taggedSubscriptionInfo) []TenantInfo {
+	for i := range tenants {
+		for _, sub := range subs {
+			if sub.TenantID == tenants[i].ID {
+				tenants[i].Subscriptions = append(tenants[i].Subscriptions, sub.SubscriptionInfo)
+			}
+		}
+	}
+	return tenants
+}
+
+// armGet issues an authenticated GET against the ARM endpoint registered for
+// uotm's cloud and returns the raw response body.
+func (uotm *UserOAuthTokenManager) armGet(pathAndQuery, accessToken string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, cloudInfo(uotm.cloud).ManagementEndpoint+pathAndQuery, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := uotm.oauthClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ARM returned status %d: %s", resp.StatusCode, string(body))
+	}
+	return body, nil
+}
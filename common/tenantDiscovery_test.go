@@ -0,0 +1,64 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeSubscriptionsIntoTenants(t *testing.T) {
+	tenants := []TenantInfo{
+		{ID: "tenant-a", DisplayName: "A"},
+		{ID: "tenant-b", DisplayName: "B"},
+	}
+	subs := []taggedSubscriptionInfo{
+		{SubscriptionInfo: SubscriptionInfo{ID: "sub-1", DisplayName: "Sub One"}, TenantID: "tenant-a"},
+		{SubscriptionInfo: SubscriptionInfo{ID: "sub-2", DisplayName: "Sub Two"}, TenantID: "tenant-a"},
+		{SubscriptionInfo: SubscriptionInfo{ID: "sub-3", DisplayName: "Sub Three"}, TenantID: "tenant-b"},
+		{SubscriptionInfo: SubscriptionInfo{ID: "sub-4", DisplayName: "Orphaned"}, TenantID: "tenant-unknown"},
+	}
+
+	got := mergeSubscriptionsIntoTenants(tenants, subs)
+
+	want := []TenantInfo{
+		{ID: "tenant-a", DisplayName: "A", Subscriptions: []SubscriptionInfo{
+			{ID: "sub-1", DisplayName: "Sub One"},
+			{ID: "sub-2", DisplayName: "Sub Two"},
+		}},
+		{ID: "tenant-b", DisplayName: "B", Subscriptions: []SubscriptionInfo{
+			{ID: "sub-3", DisplayName: "Sub Three"},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestMergeSubscriptionsIntoTenantsWithNoSubscriptions(t *testing.T) {
+	tenants := []TenantInfo{{ID: "tenant-a", DisplayName: "A"}}
+
+	got := mergeSubscriptionsIntoTenants(tenants, nil)
+
+	if len(got) != 1 || got[0].Subscriptions != nil {
+		t.Fatalf("expected tenant with no subscriptions attached, got %+v", got)
+	}
+}
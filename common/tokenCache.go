@@ -0,0 +1,153 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// TokenCache abstracts over where UserOAuthTokenManager persists the (still
+// JSON-marshaled) OAuthTokenInfo, so the on-disk encrypted file used today is
+// just one of several interchangeable backends.
+type TokenCache interface {
+	// Load returns the cached token bytes, or an error satisfying
+	// os.IsNotExist if nothing is cached yet.
+	Load() ([]byte, error)
+	// Save persists the token bytes, replacing whatever was cached before.
+	Save([]byte) error
+	// Delete removes the cached token, if any.
+	Delete() error
+	// Exists reports whether a token is currently cached.
+	Exists() bool
+}
+
+// tokenCacheBackend selects a TokenCache implementation via the
+// AZCOPY_TOKEN_CACHE environment variable.
+type tokenCacheBackend string
+
+const (
+	// TokenCacheBackendFile is the default: an encrypted JSON file on disk,
+	// protected by OS ACLs (and, on non-Windows, a user acknowledgement).
+	TokenCacheBackendFile tokenCacheBackend = "file"
+	// TokenCacheBackendKeyring stores the token in the OS-native credential
+	// store (Windows Credential Manager, macOS Keychain, Linux Secret
+	// Service), which is already a user-scoped encrypted store.
+	TokenCacheBackendKeyring tokenCacheBackend = "keyring"
+	// TokenCacheBackendMemory never touches disk; the token only lives for
+	// the lifetime of the current process, for ephemeral shells.
+	TokenCacheBackendMemory tokenCacheBackend = "memory"
+	// TokenCacheBackendEnvironment reads a pre-minted token out of an
+	// environment variable instead of logging in, for CI.
+	TokenCacheBackendEnvironment tokenCacheBackend = "environment"
+)
+
+// tokenCacheBackendEnvVar selects which TokenCache NewUserOAuthTokenManagerInstance
+// wires up; unset or unrecognized falls back to TokenCacheBackendFile.
+const tokenCacheBackendEnvVar = "AZCOPY_TOKEN_CACHE"
+
+// newTokenCacheFromEnv builds the TokenCache backend selected by
+// AZCOPY_TOKEN_CACHE, defaulting to the on-disk file cache rooted at
+// userTokenCachePath for compatibility with existing installs.
+func newTokenCacheFromEnv(userTokenCachePath string) TokenCache {
+	switch tokenCacheBackend(os.Getenv(tokenCacheBackendEnvVar)) {
+	case TokenCacheBackendKeyring:
+		return newKeyringTokenCache()
+	case TokenCacheBackendMemory:
+		return newMemoryTokenCache()
+	case TokenCacheBackendEnvironment:
+		return newEnvironmentTokenCache()
+	default:
+		return newFileTokenCache(path.Join(userTokenCachePath, "/", defaultTokenFileName))
+	}
+}
+
+//====================================================================================
+// in-memory backend
+
+// memoryTokenCache keeps the token only in process memory.
+type memoryTokenCache struct {
+	data   []byte
+	exists bool
+}
+
+func newMemoryTokenCache() *memoryTokenCache {
+	return &memoryTokenCache{}
+}
+
+func (m *memoryTokenCache) Load() ([]byte, error) {
+	if !m.exists {
+		return nil, os.ErrNotExist
+	}
+	return m.data, nil
+}
+
+func (m *memoryTokenCache) Save(b []byte) error {
+	m.data = b
+	m.exists = true
+	return nil
+}
+
+func (m *memoryTokenCache) Delete() error {
+	m.data = nil
+	m.exists = false
+	return nil
+}
+
+func (m *memoryTokenCache) Exists() bool {
+	return m.exists
+}
+
+//====================================================================================
+// CI/environment backend
+
+// environmentTokenEnvVar holds a pre-minted OAuthTokenInfo as JSON, for CI
+// systems that inject a token rather than running an interactive login.
+const environmentTokenEnvVar = "AZCOPY_OAUTH_TOKEN_INFO"
+
+// environmentTokenCache is read-only: the token comes from whatever process
+// set AZCOPY_OAUTH_TOKEN_INFO, not from azcopy itself.
+type environmentTokenCache struct{}
+
+func newEnvironmentTokenCache() *environmentTokenCache {
+	return &environmentTokenCache{}
+}
+
+func (e *environmentTokenCache) Load() ([]byte, error) {
+	v := os.Getenv(environmentTokenEnvVar)
+	if v == "" {
+		return nil, os.ErrNotExist
+	}
+	return []byte(v), nil
+}
+
+func (e *environmentTokenCache) Save([]byte) error {
+	return fmt.Errorf("the %s token cache is read-only; set %s instead", TokenCacheBackendEnvironment, environmentTokenEnvVar)
+}
+
+func (e *environmentTokenCache) Delete() error {
+	return nil
+}
+
+func (e *environmentTokenCache) Exists() bool {
+	return os.Getenv(environmentTokenEnvVar) != ""
+}
@@ -0,0 +1,136 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// fileTokenCache is the original TokenCache backend: an encrypted JSON file
+// on disk, written via a temp-file-then-rename for atomicity and guarded by
+// a cross-process file lock against concurrent readers/writers.
+type fileTokenCache struct {
+	path string
+}
+
+func newFileTokenCache(path string) *fileTokenCache {
+	return &fileTokenCache{path: path}
+}
+
+func (f *fileTokenCache) lockPath() string {
+	// A separate file (rather than the token file itself) is locked so that
+	// Save's atomic rename doesn't invalidate a lock held by another process
+	// on the old inode.
+	return f.path + ".lock"
+}
+
+func (f *fileTokenCache) Exists() bool {
+	_, err := os.Stat(f.path)
+	return err == nil
+}
+
+func (f *fileTokenCache) Delete() error {
+	if _, err := os.Stat(f.path); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("fail to stat cached token file with path: %s, due to error: %v", f.path, err.Error())
+	}
+
+	if err := os.Remove(f.path); err != nil {
+		return fmt.Errorf("failed to remove cached token file with path: %s, due to error: %v", f.path, err.Error())
+	}
+	return nil
+}
+
+// Load reads and decrypts the token file located at f.path.
+func (f *fileTokenCache) Load() ([]byte, error) {
+	// Returned unwrapped so that a missing token file satisfies os.IsNotExist.
+	if _, err := os.Stat(f.path); err != nil {
+		return nil, err
+	}
+
+	lock, err := lockFile(f.lockPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock token file (%s) while loading token: %v", f.path, err)
+	}
+	defer lock.Unlock()
+
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token file (%s) while loading token: %v", f.path, err)
+	}
+
+	decryptedB, err := gEncryptionUtil.Decrypt(b)
+	if err != nil {
+		return nil, fmt.Errorf("fail to decrypt bytes: %s", err.Error())
+	}
+
+	return decryptedB, nil
+}
+
+// Save encrypts b and persists it at f.path, with read/write permissions for
+// the owner of the file only.
+func (f *fileTokenCache) Save(b []byte) error {
+	// The lock file lives next to the token file, so the directory must
+	// exist before lockFile can create/open it - on a fresh install this
+	// directory doesn't exist yet, so MkdirAll has to run first.
+	dir := filepath.Dir(f.path)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directory (%s) to store token in: %v", dir, err)
+	}
+
+	lock, err := lockFile(f.lockPath())
+	if err != nil {
+		return fmt.Errorf("failed to lock token file (%s) while saving token: %v", f.path, err)
+	}
+	defer lock.Unlock()
+
+	newFile, err := ioutil.TempFile(dir, "token")
+	if err != nil {
+		return fmt.Errorf("failed to create the temp file to write the token: %v", err)
+	}
+	tempPath := newFile.Name()
+
+	encryptedB, err := gEncryptionUtil.Encrypt(b)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt token: %v", err)
+	}
+
+	if _, err = newFile.Write(encryptedB); err != nil {
+		return fmt.Errorf("failed to encode token to file (%s) while saving token: %v", tempPath, err)
+	}
+	if err := newFile.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file %s: %v", tempPath, err)
+	}
+
+	// Atomic replace to avoid multi-writer file corruptions
+	if err := os.Rename(tempPath, f.path); err != nil {
+		return fmt.Errorf("failed to move temporary token to desired output location. src=%s dst=%s: %v", tempPath, f.path, err)
+	}
+	if err := os.Chmod(f.path, 0600); err != nil {
+		return fmt.Errorf("failed to chmod the token file %s: %v", f.path, err)
+	}
+	return nil
+}
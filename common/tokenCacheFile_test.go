@@ -0,0 +1,50 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestFileTokenCacheSaveCreatesMissingDirectory guards against a regression
+// where Save locked the cache directory's lock file before creating that
+// directory, so the very first login on a fresh install (nothing under
+// userTokenCachePath yet) failed to persist its token.
+func TestFileTokenCacheSaveCreatesMissingDirectory(t *testing.T) {
+	root := t.TempDir()
+	cache := newFileTokenCache(filepath.Join(root, "nested", "does", "not", "exist", defaultTokenFileName))
+
+	if err := cache.Save([]byte("token-bytes")); err != nil {
+		t.Fatalf("Save should create missing parent directories, got error: %v", err)
+	}
+	if !cache.Exists() {
+		t.Fatal("expected token file to exist after Save")
+	}
+
+	got, err := cache.Load()
+	if err != nil {
+		t.Fatalf("Load failed after Save: %v", err)
+	}
+	if string(got) != "token-bytes" {
+		t.Fatalf("got %q, want %q", got, "token-bytes")
+	}
+}
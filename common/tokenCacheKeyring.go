@@ -0,0 +1,74 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import (
+	"os"
+
+	"github.com/zalando/go-keyring"
+)
+
+// keyringService namespaces azcopy's entries in the OS credential store.
+const keyringService = "AzCopy"
+
+// keyringUser is the only account azcopy keeps a cached token for today;
+// there is one login at a time, same as the file-based cache.
+const keyringUser = "token"
+
+// keyringTokenCache stores the token in the OS-native credential store:
+// Windows Credential Manager (wincred), macOS Keychain (Security.framework)
+// or Linux Secret Service (libsecret/D-Bus), via go-keyring's cross-platform
+// wrapper over all three. Because that store is already a user-scoped
+// encrypted store, azcopy skips its own ACL-robustness warning when this
+// backend is selected (see LoginWithADEndpoint).
+type keyringTokenCache struct{}
+
+func newKeyringTokenCache() *keyringTokenCache {
+	return &keyringTokenCache{}
+}
+
+func (k *keyringTokenCache) Load() ([]byte, error) {
+	v, err := keyring.Get(keyringService, keyringUser)
+	if err != nil {
+		if err == keyring.ErrNotFound {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	return []byte(v), nil
+}
+
+func (k *keyringTokenCache) Save(b []byte) error {
+	return keyring.Set(keyringService, keyringUser, string(b))
+}
+
+func (k *keyringTokenCache) Delete() error {
+	err := keyring.Delete(keyringService, keyringUser)
+	if err == keyring.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (k *keyringTokenCache) Exists() bool {
+	_, err := keyring.Get(keyringService, keyringUser)
+	return err == nil
+}
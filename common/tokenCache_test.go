@@ -0,0 +1,106 @@
+// Copyright © 2017 Microsoft <wastore@microsoft.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package common
+
+import "testing"
+
+// tokenCacheRoundTrip exercises the Exists/Save/Load/Delete contract every
+// TokenCache backend is expected to satisfy.
+func tokenCacheRoundTrip(t *testing.T, cache TokenCache) {
+	t.Helper()
+
+	if cache.Exists() {
+		t.Fatal("expected a fresh cache to report Exists() == false")
+	}
+	if _, err := cache.Load(); err == nil {
+		t.Fatal("expected Load() to fail before anything is saved")
+	}
+
+	if err := cache.Save([]byte("token-bytes")); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if !cache.Exists() {
+		t.Fatal("expected Exists() == true after Save")
+	}
+
+	got, err := cache.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if string(got) != "token-bytes" {
+		t.Fatalf("got %q, want %q", got, "token-bytes")
+	}
+
+	if err := cache.Delete(); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if cache.Exists() {
+		t.Fatal("expected Exists() == false after Delete")
+	}
+}
+
+func TestMemoryTokenCacheRoundTrip(t *testing.T) {
+	tokenCacheRoundTrip(t, newMemoryTokenCache())
+}
+
+func TestEnvironmentTokenCacheIsReadOnly(t *testing.T) {
+	t.Setenv(environmentTokenEnvVar, "")
+	cache := newEnvironmentTokenCache()
+
+	if cache.Exists() {
+		t.Fatal("expected Exists() == false when AZCOPY_OAUTH_TOKEN_INFO is unset")
+	}
+	if _, err := cache.Load(); err == nil {
+		t.Fatal("expected Load() to fail when AZCOPY_OAUTH_TOKEN_INFO is unset")
+	}
+	if err := cache.Save([]byte("anything")); err == nil {
+		t.Fatal("expected Save() to fail: the environment cache is read-only")
+	}
+
+	t.Setenv(environmentTokenEnvVar, "token-bytes")
+	if !cache.Exists() {
+		t.Fatal("expected Exists() == true once AZCOPY_OAUTH_TOKEN_INFO is set")
+	}
+	got, err := cache.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if string(got) != "token-bytes" {
+		t.Fatalf("got %q, want %q", got, "token-bytes")
+	}
+}
+
+func TestNewTokenCacheFromEnvSelectsBackend(t *testing.T) {
+	t.Setenv(tokenCacheBackendEnvVar, string(TokenCacheBackendMemory))
+	if _, ok := newTokenCacheFromEnv(t.TempDir()).(*memoryTokenCache); !ok {
+		t.Fatal("expected AZCOPY_TOKEN_CACHE=memory to select memoryTokenCache")
+	}
+
+	t.Setenv(tokenCacheBackendEnvVar, string(TokenCacheBackendEnvironment))
+	if _, ok := newTokenCacheFromEnv(t.TempDir()).(*environmentTokenCache); !ok {
+		t.Fatal("expected AZCOPY_TOKEN_CACHE=environment to select environmentTokenCache")
+	}
+
+	t.Setenv(tokenCacheBackendEnvVar, "")
+	if _, ok := newTokenCacheFromEnv(t.TempDir()).(*fileTokenCache); !ok {
+		t.Fatal("expected an unset AZCOPY_TOKEN_CACHE to default to fileTokenCache")
+	}
+}